@@ -0,0 +1,233 @@
+package main
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// token is one whitespace-delimited word produced by tokenize. text is
+// its literal value; pattern is the same word but with any glob
+// metacharacter that came from inside quotes escaped (`\`-prefixed) so
+// that per-fragment
+// quoting survives into globExpand — a token like `"a"*` must still
+// glob on its unquoted `*` while the quoted `a` stays literal.
+type token struct {
+	text    string
+	pattern string
+}
+
+// globMeta are the bytes globExpand treats as glob metacharacters, plus
+// '\\' itself since escaping one requires escaping the other.
+const globMeta = "*?[]\\"
+
+// tokenize splits a raw input line into tokens, expanding $NAME/${NAME}
+// and $(...)/`...` command substitution as it goes, and handling
+// single/double quoting and backslash escapes. `|` and `&` are emitted
+// as their own tokens whenever they appear unquoted.
+func tokenize(cmd string) []token {
+	var tokens []token
+	var current, pattern strings.Builder
+	var hasCurrent bool
+	var isSingleQuoted, isDoubleQuoted bool
+
+	flush := func() {
+		if hasCurrent {
+			tokens = append(tokens, token{text: current.String(), pattern: pattern.String()})
+			current.Reset()
+			pattern.Reset()
+			hasCurrent = false
+		}
+	}
+	add := func(s string, quoted bool) {
+		current.WriteString(s)
+		hasCurrent = true
+		if !quoted {
+			pattern.WriteString(s)
+			return
+		}
+		for i := 0; i < len(s); i++ {
+			if strings.IndexByte(globMeta, s[i]) >= 0 {
+				pattern.WriteByte('\\')
+			}
+			pattern.WriteByte(s[i])
+		}
+	}
+
+	for i := 0; i < len(cmd); i++ {
+		c := cmd[i]
+		switch {
+		case !isDoubleQuoted && !isSingleQuoted && c == '\\':
+			if i+1 < len(cmd) {
+				add(string(cmd[i+1]), false)
+				i++
+			}
+		case isSingleQuoted:
+			if c == '\'' {
+				isSingleQuoted = false
+			} else {
+				add(string(c), true)
+			}
+		case isDoubleQuoted:
+			switch {
+			case c == '"':
+				isDoubleQuoted = false
+			case c == '\\' && i+1 < len(cmd) && (cmd[i+1] == '\\' || cmd[i+1] == '$' || cmd[i+1] == '"'):
+				add(string(cmd[i+1]), true)
+				i++
+			case c == '$':
+				expanded, consumed := expandDollar(cmd[i:])
+				add(expanded, true)
+				i += consumed - 1
+			case c == '`':
+				inner, consumed := scanBacktick(cmd[i:])
+				add(runCommandSubstitution(inner), true)
+				i += consumed - 1
+			default:
+				add(string(c), true)
+			}
+		case c == ' ':
+			flush()
+		case c == '|' || c == '&':
+			flush()
+			tokens = append(tokens, token{text: string(c), pattern: string(c)})
+		case c == '\'':
+			isSingleQuoted = true
+		case c == '"':
+			isDoubleQuoted = true
+		case c == '$':
+			expanded, consumed := expandDollar(cmd[i:])
+			add(expanded, false)
+			i += consumed - 1
+		case c == '`':
+			inner, consumed := scanBacktick(cmd[i:])
+			add(runCommandSubstitution(inner), false)
+			i += consumed - 1
+		default:
+			add(string(c), false)
+		}
+	}
+	flush()
+	return tokens
+}
+
+// expandDollar expands the `$...` expression starting at s[0] (which
+// must be '$'), returning the replacement text and how many bytes of s
+// it consumed. An unrecognized form (e.g. "$" at end of string, or
+// followed by a character that can't start a name) is left as a literal
+// "$".
+func expandDollar(s string) (string, int) {
+	if len(s) < 2 {
+		return "$", 1
+	}
+	switch s[1] {
+	case '(':
+		depth := 1
+		j := 2
+		for j < len(s) && depth > 0 {
+			switch s[j] {
+			case '(':
+				depth++
+			case ')':
+				depth--
+			}
+			j++
+		}
+		if depth > 0 {
+			return "$", 1
+		}
+		return runCommandSubstitution(s[2 : j-1]), j
+	case '{':
+		end := strings.IndexByte(s[2:], '}')
+		if end < 0 {
+			return "$", 1
+		}
+		return os.Getenv(s[2 : 2+end]), 2 + end + 1
+	case '?':
+		return strconv.Itoa(lastStatus), 2
+	default:
+		j := 1
+		for j < len(s) && isNameByte(s[j]) {
+			j++
+		}
+		if j == 1 {
+			return "$", 1
+		}
+		return os.Getenv(s[1:j]), j
+	}
+}
+
+func isNameByte(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+}
+
+// scanBacktick extracts the command text inside a backtick pair starting
+// at s[0] (which must be '`'), returning the inner text and how many
+// bytes of s it consumed. An escaped backtick or backslash inside is
+// unescaped, matching the tokenizer's double-quote escaping.
+func scanBacktick(s string) (string, int) {
+	var inner strings.Builder
+	j := 1
+	for j < len(s) && s[j] != '`' {
+		if s[j] == '\\' && j+1 < len(s) && (s[j+1] == '`' || s[j+1] == '\\') {
+			inner.WriteByte(s[j+1])
+			j += 2
+			continue
+		}
+		inner.WriteByte(s[j])
+		j++
+	}
+	if j < len(s) {
+		j++ // consume closing '`'
+	}
+	return inner.String(), j
+}
+
+// globExpand walks tokenize's output, replacing any token that contains
+// an unquoted glob metacharacter with its sorted filepath.Glob matches.
+// It globs on t.pattern rather than t.text so that fragments which came
+// from inside quotes (already backslash-escaped there) are matched
+// literally while unquoted fragments of the same token still expand —
+// matching bash's handling of tokens like `"a"*`. A token with no
+// matches passes through using its literal text.
+func globExpand(tokens []token) []string {
+	var words []string
+	for _, t := range tokens {
+		if !strings.ContainsAny(t.pattern, "*?[") {
+			words = append(words, t.text)
+			continue
+		}
+		matches, err := filepath.Glob(t.pattern)
+		if err != nil || len(matches) == 0 {
+			words = append(words, t.text)
+			continue
+		}
+		sort.Strings(matches)
+		words = append(words, matches...)
+	}
+	return words
+}
+
+// handleExport sets one or more NAME=value pairs in the shell's
+// environment, so child processes and `$NAME` expansion both see them.
+func handleExport(args []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	for _, arg := range args {
+		name, value, ok := strings.Cut(arg, "=")
+		if !ok {
+			continue
+		}
+		os.Setenv(name, value)
+	}
+	return nil
+}
+
+// handleUnset removes one or more variables from the shell's environment.
+func handleUnset(args []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	for _, name := range args {
+		os.Unsetenv(name)
+	}
+	return nil
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// JobState tracks where a job stands relative to the process group the
+// shell launched for it.
+type JobState int
+
+const (
+	JobRunning JobState = iota
+	JobStopped
+	JobDone
+)
+
+func (s JobState) String() string {
+	switch s {
+	case JobRunning:
+		return "Running"
+	case JobStopped:
+		return "Stopped"
+	default:
+		return "Done"
+	}
+}
+
+// Job is one pipeline launched with a trailing `&`, or a foreground
+// pipeline that got suspended with Ctrl-Z and is now running (or
+// stopped) in its own process group. LastPid is the pid of the
+// pipeline's last stage, used to report that stage's exit status
+// rather than whichever process in the group happens to be reaped last.
+type Job struct {
+	ID      int
+	Pgid    int
+	LastPid int
+	Cmdline string
+	State   JobState
+}
+
+var (
+	jobList   []*Job
+	nextJobID = 1
+
+	// foregroundPgid is the process group currently occupying the
+	// foreground, or 0 when the shell itself has control. SIGINT/SIGTSTP
+	// received by the shell are forwarded here instead of acting on the
+	// shell itself.
+	foregroundPgid int
+)
+
+func addJob(pgid, lastPid int, cmdline string) *Job {
+	j := &Job{ID: nextJobID, Pgid: pgid, LastPid: lastPid, Cmdline: cmdline, State: JobRunning}
+	nextJobID++
+	jobList = append(jobList, j)
+	return j
+}
+
+func findJob(spec string) *Job {
+	spec = strings.TrimPrefix(spec, "%")
+	if spec == "" || spec == "+" || spec == "-" {
+		if len(jobList) == 0 {
+			return nil
+		}
+		return jobList[len(jobList)-1]
+	}
+	id, err := strconv.Atoi(spec)
+	if err != nil {
+		return nil
+	}
+	for _, j := range jobList {
+		if j.ID == id {
+			return j
+		}
+	}
+	return nil
+}
+
+func removeJob(j *Job) {
+	for i, cur := range jobList {
+		if cur == j {
+			jobList = append(jobList[:i], jobList[i+1:]...)
+			return
+		}
+	}
+}
+
+// waitForegroundPgid blocks, reaping children in pgid as they exit, until
+// either every process in the group has exited (returns false, plus
+// lastPid's exit status) or one of them stops (returns true, leaving the
+// rest of the group alive). lastPid identifies the pipeline's last stage
+// so the reported status is that stage's, not whichever process in the
+// group happens to be reaped last; pass 0 to fall back to the latter.
+func waitForegroundPgid(pgid, lastPid int) (stopped bool, reaped syscall.WaitStatus) {
+	for {
+		var status syscall.WaitStatus
+		pid, err := syscall.Wait4(-pgid, &status, syscall.WUNTRACED, nil)
+		if err != nil {
+			return false, reaped
+		}
+		if status.Stopped() {
+			return true, status
+		}
+		if lastPid == 0 || pid == lastPid {
+			reaped = status
+		}
+	}
+}
+
+// reapJobs polls every tracked job's process group for children that
+// have exited, without blocking, and prints a "Done" line for any job
+// that finished since the last prompt. Called once per prompt redraw.
+func reapJobs() {
+	for _, j := range jobList {
+		if j.State == JobDone {
+			continue
+		}
+		for {
+			var status syscall.WaitStatus
+			pid, err := syscall.Wait4(-j.Pgid, &status, syscall.WNOHANG, nil)
+			if err == syscall.ECHILD {
+				j.State = JobDone
+				break
+			}
+			if err != nil || pid <= 0 {
+				break
+			}
+		}
+		if j.State == JobDone {
+			fmt.Printf("[%d]+ Done\t%s\n", j.ID, j.Cmdline)
+		}
+	}
+	kept := jobList[:0]
+	for _, j := range jobList {
+		if j.State != JobDone {
+			kept = append(kept, j)
+		}
+	}
+	jobList = kept
+}
+
+func handleJobs(args []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	for _, j := range jobList {
+		fmt.Fprintf(stdout, "[%d]  %s\t\t%s\n", j.ID, j.State, j.Cmdline)
+	}
+	return nil
+}
+
+func handleFg(args []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	var spec string
+	if len(args) > 0 {
+		spec = args[0]
+	}
+	j := findJob(spec)
+	if j == nil {
+		return fmt.Errorf("fg: %s: no such job", spec)
+	}
+	fmt.Fprintln(stdout, j.Cmdline)
+	syscall.Kill(-j.Pgid, syscall.SIGCONT)
+	j.State = JobRunning
+	foregroundPgid = j.Pgid
+	stopped, ws := waitForegroundPgid(j.Pgid, j.LastPid)
+	foregroundPgid = 0
+	if stopped {
+		j.State = JobStopped
+		fmt.Printf("\n[%d]+  Stopped\t%s\n", j.ID, j.Cmdline)
+	} else {
+		removeJob(j)
+		lastStatus = ws.ExitStatus()
+	}
+	return nil
+}
+
+func handleBg(args []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	var spec string
+	if len(args) > 0 {
+		spec = args[0]
+	}
+	j := findJob(spec)
+	if j == nil {
+		return fmt.Errorf("bg: %s: no such job", spec)
+	}
+	syscall.Kill(-j.Pgid, syscall.SIGCONT)
+	j.State = JobRunning
+	fmt.Fprintf(stdout, "[%d]+ %s &\n", j.ID, j.Cmdline)
+	return nil
+}
+
+func handleWait(args []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	for _, j := range jobList {
+		if j.State == JobDone {
+			continue
+		}
+		_, _ = waitForegroundPgid(j.Pgid, j.LastPid)
+	}
+	reapJobs()
+	return nil
+}
+
+func handleKill(args []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	if len(args) == 0 {
+		return fmt.Errorf("kill: usage: kill %%n")
+	}
+	j := findJob(args[0])
+	if j == nil {
+		return fmt.Errorf("kill: %s: no such job", args[0])
+	}
+	return syscall.Kill(-j.Pgid, syscall.SIGTERM)
+}
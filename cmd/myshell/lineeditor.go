@@ -0,0 +1,357 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"golang.org/x/term"
+)
+
+// LineEditor is a small readline-style line editor: it keeps the current
+// line as a rune buffer plus a cursor position (rather than the
+// string-append approach readInput used to use) so arrow keys, word/line
+// kills and reverse search all have somewhere to operate.
+type LineEditor struct {
+	buf         []rune
+	pos         int
+	history     []string
+	historyFile string
+}
+
+func historyFilePath() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".gosh_history")
+}
+
+// NewLineEditor creates an editor and loads ~/.gosh_history, if any.
+func NewLineEditor() *LineEditor {
+	le := &LineEditor{historyFile: historyFilePath()}
+	if le.historyFile == "" {
+		return le
+	}
+	data, err := os.ReadFile(le.historyFile)
+	if err != nil {
+		return le
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if line != "" {
+			le.history = append(le.history, line)
+		}
+	}
+	return le
+}
+
+// appendHistory records a line in memory and on disk. We append as each
+// line is entered rather than batching writes until exit, since `exit`
+// calls os.Exit directly and would skip a deferred flush.
+func (le *LineEditor) appendHistory(line string) {
+	if line == "" {
+		return
+	}
+	le.history = append(le.history, line)
+	if le.historyFile == "" {
+		return
+	}
+	f, err := os.OpenFile(le.historyFile, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	fmt.Fprintln(f, line)
+}
+
+func (le *LineEditor) redraw(prompt string) {
+	fmt.Print("\r\x1b[K", prompt, string(le.buf))
+	if back := len(le.buf) - le.pos; back > 0 {
+		fmt.Printf("\x1b[%dD", back)
+	}
+}
+
+func (le *LineEditor) insert(ch rune) {
+	le.buf = slices.Insert(le.buf, le.pos, ch)
+	le.pos++
+}
+
+func (le *LineEditor) deleteBack() {
+	if le.pos == 0 {
+		return
+	}
+	le.buf = slices.Delete(le.buf, le.pos-1, le.pos)
+	le.pos--
+}
+
+// killPrevWord implements Ctrl-W: delete back to the start of the
+// previous word.
+func (le *LineEditor) killPrevWord() {
+	i := le.pos
+	for i > 0 && le.buf[i-1] == ' ' {
+		i--
+	}
+	for i > 0 && le.buf[i-1] != ' ' {
+		i--
+	}
+	le.buf = slices.Delete(le.buf, i, le.pos)
+	le.pos = i
+}
+
+// searchHistory looks backward from index `before` for the most recent
+// entry containing query, returning it and its index, or ("", -1).
+func (le *LineEditor) searchHistory(query string, before int) (string, int) {
+	if query == "" {
+		return "", -1
+	}
+	for i := before - 1; i >= 0; i-- {
+		if strings.Contains(le.history[i], query) {
+			return le.history[i], i
+		}
+	}
+	return "", -1
+}
+
+// ReadLine reads one line from reader using the terminal in raw mode,
+// supporting history navigation (Up/Down), cursor movement (Left/Right,
+// Ctrl-A/E), line/word kills (Ctrl-U/K/W) and incremental reverse
+// search (Ctrl-R).
+func (le *LineEditor) ReadLine(reader io.Reader, prompt string) string {
+	prevState, err := term.MakeRaw(int(os.Stdin.Fd()))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error Reading Input")
+		panic(err)
+	}
+	defer term.Restore(int(os.Stdin.Fd()), prevState)
+
+	le.buf = le.buf[:0]
+	le.pos = 0
+	histIdx := len(le.history)
+	saved := ""
+
+	searching := false
+	searchQuery := ""
+	searchMatch := ""
+	searchIdx := len(le.history)
+
+	r := bufio.NewReader(reader)
+
+	redraw := func() {
+		if searching {
+			fmt.Printf("\r\x1b[K(reverse-i-search)'%s': %s", searchQuery, searchMatch)
+			return
+		}
+		le.redraw(prompt)
+	}
+	redraw()
+
+loop:
+	for {
+		ch, _, err := r.ReadRune()
+		if err != nil {
+			fmt.Println(err)
+			continue
+		}
+
+		if searching {
+			switch ch {
+			case '\r', '\n':
+				if searchMatch != "" {
+					le.buf = []rune(searchMatch)
+					le.pos = len(le.buf)
+				}
+				searching = false
+				fmt.Fprint(os.Stdout, "\r\n")
+				break loop
+			case '\x03': // Ctrl-C cancels the search, keeping the line empty
+				searching = false
+				le.buf = le.buf[:0]
+				le.pos = 0
+				redraw()
+			case '\x12': // Ctrl-R again: look further back
+				if match, idx := le.searchHistory(searchQuery, searchIdx); idx >= 0 {
+					searchMatch, searchIdx = match, idx
+				}
+				redraw()
+			case '\x7F':
+				if len(searchQuery) > 0 {
+					searchQuery = searchQuery[:len(searchQuery)-1]
+				}
+				searchIdx = len(le.history)
+				if match, idx := le.searchHistory(searchQuery, searchIdx); idx >= 0 {
+					searchMatch, searchIdx = match, idx
+				} else {
+					searchMatch = ""
+				}
+				redraw()
+			default:
+				searchQuery += string(ch)
+				searchIdx = len(le.history)
+				if match, idx := le.searchHistory(searchQuery, searchIdx); idx >= 0 {
+					searchMatch, searchIdx = match, idx
+				} else {
+					searchMatch = ""
+				}
+				redraw()
+			}
+			continue
+		}
+
+		switch ch {
+		case '\x1b': // arrow keys: ESC [ A/B/C/D
+			_, _, _ = r.ReadRune() // '['
+			b, _, _ := r.ReadRune()
+			switch b {
+			case 'A': // Up
+				if histIdx > 0 {
+					if histIdx == len(le.history) {
+						saved = string(le.buf)
+					}
+					histIdx--
+					le.buf = []rune(le.history[histIdx])
+					le.pos = len(le.buf)
+				}
+			case 'B': // Down
+				if histIdx < len(le.history) {
+					histIdx++
+					if histIdx == len(le.history) {
+						le.buf = []rune(saved)
+					} else {
+						le.buf = []rune(le.history[histIdx])
+					}
+					le.pos = len(le.buf)
+				}
+			case 'C': // Right
+				if le.pos < len(le.buf) {
+					le.pos++
+				}
+			case 'D': // Left
+				if le.pos > 0 {
+					le.pos--
+				}
+			}
+			redraw()
+		case '\x01': // Ctrl-A
+			le.pos = 0
+			redraw()
+		case '\x05': // Ctrl-E
+			le.pos = len(le.buf)
+			redraw()
+		case '\x15': // Ctrl-U: kill to start of line
+			le.buf = le.buf[le.pos:]
+			le.pos = 0
+			redraw()
+		case '\x0B': // Ctrl-K: kill to end of line
+			le.buf = le.buf[:le.pos]
+			redraw()
+		case '\x17': // Ctrl-W: kill previous word
+			le.killPrevWord()
+			redraw()
+		case '\x12': // Ctrl-R: enter reverse search
+			searching = true
+			searchQuery = ""
+			searchMatch = ""
+			searchIdx = len(le.history)
+			redraw()
+		case '\x03': // Ctrl + C
+			if foregroundPgid != 0 {
+				syscall.Kill(-foregroundPgid, syscall.SIGINT)
+			}
+			fmt.Fprint(os.Stdout, "^C\r\n")
+			le.buf = le.buf[:0]
+			break loop
+		case '\x1a': // Ctrl + Z
+			if foregroundPgid != 0 {
+				syscall.Kill(-foregroundPgid, syscall.SIGTSTP)
+			}
+			fmt.Fprint(os.Stdout, "\r\n")
+			le.buf = le.buf[:0]
+			break loop
+		case '\x7F': // backspace
+			le.deleteBack()
+			redraw()
+		case '\r', '\n': // enter
+			fmt.Fprint(os.Stdout, "\r\n")
+			break loop
+		case '\t': // tab
+			matches := autoComplete(string(le.buf))
+			if len(matches) <= 1 {
+				if len(matches) != 0 {
+					for _, m := range matches[0] + " " {
+						le.insert(m)
+					}
+				} else {
+					fmt.Print("\a")
+				}
+			} else {
+				partialMatch := longestCommonPrefix(matches)
+				if partialMatch == "" {
+					fmt.Print("\a")
+					next, _, err := r.ReadRune()
+					if err != nil {
+						fmt.Println(err)
+						continue
+					}
+					if next == '\t' {
+						slices.Sort(matches)
+						fmt.Fprint(os.Stdout, "\r\n")
+						for _, match := range matches {
+							fmt.Fprint(os.Stdout, string(le.buf)+match+"  ")
+						}
+						fmt.Fprint(os.Stdout, "\r\n")
+					}
+				} else {
+					for _, m := range partialMatch {
+						le.insert(m)
+					}
+				}
+			}
+			redraw()
+		default:
+			le.insert(ch)
+			redraw()
+		}
+	}
+
+	line := string(le.buf)
+	// Record the expanded form so a later "!!" repeats the command that
+	// actually ran, not the literal "!!" that requested it.
+	le.appendHistory(expandHistory(line, le.history))
+	return line
+}
+
+// expandHistory resolves `!!` (previous command) and `!n` (command n,
+// 1-indexed) before the line reaches the tokenizer, matching the classic
+// shell history-expansion shorthand.
+func expandHistory(line string, history []string) string {
+	trimmed := strings.TrimSpace(line)
+	if !strings.HasPrefix(trimmed, "!") {
+		return line
+	}
+	if trimmed == "!!" {
+		if len(history) == 0 {
+			return line
+		}
+		return history[len(history)-1]
+	}
+	if n, err := strconv.Atoi(trimmed[1:]); err == nil {
+		if n < 1 || n > len(history) {
+			return line
+		}
+		return history[n-1]
+	}
+	return line
+}
+
+func handleHistory(args []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	for i, line := range editor.history {
+		fmt.Fprintf(stdout, "%5d  %s\n", i+1, line)
+	}
+	return nil
+}
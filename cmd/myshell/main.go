@@ -5,30 +5,29 @@ import (
 	"fmt"
 	"io"
 	"os"
-	"os/exec"
+	"os/signal"
 	"path/filepath"
-	"slices"
 	"strconv"
 	"strings"
-
-	"golang.org/x/term"
+	"syscall"
 )
 
-var Handlers = make(map[string]func(args []string) error)
+var Handlers = make(map[string]func(args []string, stdin io.Reader, stdout, stderr io.Writer) error)
 var input *os.File = os.Stdin
-var output *os.File = os.Stdout
-var errors *os.File = os.Stderr
+var editor = NewLineEditor()
+
+// lastStatus is the exit status of the last pipeline run, exposed to the
+// parser as `$?` and used by handleExit when it's given no argument.
+var lastStatus int
 
-func handleExit(args []string) error {
-	var (
-		exitCode int
-		err      error
-	)
+func handleExit(args []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	exitCode := lastStatus
 	if len(args) == 1 {
-		exitCode, err = strconv.Atoi(args[0])
+		code, err := strconv.Atoi(args[0])
 		if err != nil {
 			return err
 		}
+		exitCode = code
 	}
 	os.Exit(exitCode)
 	return nil
@@ -46,32 +45,32 @@ func locateCmd(cmd string) (string, bool) {
 	return "", false
 }
 
-func handleEcho(args []string) error {
+func handleEcho(args []string, stdin io.Reader, stdout, stderr io.Writer) error {
 	if len(args) == 0 {
-		fmt.Fprintln(output)
+		fmt.Fprintln(stdout)
 		return nil
 	}
 	for i := 0; i < len(args)-1; i++ {
-		fmt.Fprintf(output, "%s ", args[i])
+		fmt.Fprintf(stdout, "%s ", args[i])
 	}
-	fmt.Fprintln(output, args[len(args)-1])
+	fmt.Fprintln(stdout, args[len(args)-1])
 	return nil
 }
 
-func handleType(args []string) error {
+func handleType(args []string, stdin io.Reader, stdout, stderr io.Writer) error {
 	if len(args) != 1 {
 		return nil
 	}
 	cmd := args[0]
 	if _, ok := Handlers[cmd]; ok {
-		fmt.Fprintf(output, "%s is a shell builtin\n\r", cmd)
+		fmt.Fprintf(stdout, "%s is a shell builtin\n\r", cmd)
 		return nil
 	}
 	if path, ok := locateCmd(cmd); ok {
-		fmt.Fprintf(output, "%s is %s\n\r", cmd, path)
+		fmt.Fprintf(stdout, "%s is %s\n\r", cmd, path)
 		return nil
 	}
-	fmt.Fprintf(errors, "%s: not found\n", cmd)
+	fmt.Fprintf(stderr, "%s: not found\n", cmd)
 	return nil
 }
 
@@ -80,168 +79,110 @@ func handleFileOpening(name string, flag int, perm os.FileMode, def *os.File) *o
 	if err == nil {
 		return file
 	} else {
-		fmt.Fprintf(errors, "Error opening output file: %v\n", err)
+		fmt.Fprintf(os.Stderr, "Error opening output file: %v\n", err)
 		return def
 	}
 }
 
-func handlePwd(args []string) error {
+func handlePwd(args []string, stdin io.Reader, stdout, stderr io.Writer) error {
 	mydir, err := os.Getwd()
 	if err != nil {
 		return err
 	}
-	fmt.Fprintln(output, mydir)
+	fmt.Fprintln(stdout, mydir)
 	return nil
 }
 
-func handleCd(args []string) error {
+func handleCd(args []string, stdin io.Reader, stdout, stderr io.Writer) error {
 	if args[0] == "~" {
 		args[0], _ = os.UserHomeDir()
 	}
 	if err := os.Chdir(args[0]); err != nil {
-		fmt.Fprintf(output, "%s: No such file or directory\n\r", args[0])
+		fmt.Fprintf(stdout, "%s: No such file or directory\n\r", args[0])
 	}
 	return nil
 }
 
-func handleCat(args []string) error {
+func handleCat(args []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	if len(args) == 0 {
+		_, err := io.Copy(stdout, stdin)
+		return err
+	}
 	result := ""
 	for i := 0; i < len(args); i++ {
 		data, err := os.ReadFile(args[i])
 		if err != nil {
-			fmt.Fprintln(errors, "Error reading file:", args[i])
+			fmt.Fprintln(stderr, "Error reading file:", args[i])
 			continue
 		}
 		result = result + string(data)
 	}
-	fmt.Fprint(output, result+"\n")
-	output.Sync()
+	fmt.Fprint(stdout, result+"\n")
 	return nil
 }
 
-func parseInput(cmd string) []string {
-	cmd = strings.Trim(cmd, "\n\r")
-	var parts []string
-	var currentString string = ""
-	var isSingleQuoted bool = false
-	var isDoubleQuoted bool = false
-	for i := 0; i < len(cmd); i++ {
-		if !isDoubleQuoted && !isSingleQuoted && cmd[i] == '\\' {
-			if i+1 < len(cmd) {
-				currentString += string(cmd[i+1])
-			}
-			i++
-			continue
-		} else if isSingleQuoted {
-			if cmd[i] == '\'' {
-				isSingleQuoted = false
-			} else {
-				currentString += string(cmd[i])
-			}
-			continue
-		} else if isDoubleQuoted {
-			if cmd[i] == '"' {
-				isDoubleQuoted = false
-			} else {
-				if cmd[i] == '\\' {
-					if i+1 < len(cmd) && (cmd[i+1] == '\\' || cmd[i+1] == '$' || cmd[i+1] == '"') {
-						currentString += string(cmd[i+1])
-						i++
-						continue
-					}
-				}
-				currentString += string(cmd[i])
-			}
-			continue
-		} else if cmd[i] == ' ' {
-			if len(currentString) > 0 {
-				parts = append(parts, currentString)
-				currentString = ""
-			}
-			continue
-		}
-		if cmd[i] == '\'' {
-			isSingleQuoted = true
-		} else if cmd[i] == '"' {
-			isDoubleQuoted = true
-		} else {
-			currentString += string(cmd[i])
+// buildPipeline groups tokens into pipeline stages split on "|", peels a
+// trailing "&" off into Pipeline.Background, and pulls redirection
+// operators and their targets out of each stage's argv.
+func buildPipeline(tokens []string) *Pipeline {
+	p := &Pipeline{}
+	if len(tokens) == 0 {
+		return p
+	}
+	if tokens[len(tokens)-1] == "&" {
+		p.Background = true
+		tokens = tokens[:len(tokens)-1]
+	}
+
+	var stageTokens []string
+	flushStage := func() {
+		if len(stageTokens) > 0 {
+			p.Stages = append(p.Stages, parseStage(stageTokens))
+			stageTokens = nil
 		}
 	}
-	if len(currentString) > 0 {
-		parts = append(parts, currentString)
+	for _, tok := range tokens {
+		if tok == "|" {
+			flushStage()
+			continue
+		}
+		stageTokens = append(stageTokens, tok)
 	}
-	return parts
+	flushStage()
+	return p
 }
 
-func readInput(reader io.Reader) (input string) {
-	prevState, err := term.MakeRaw(int(os.Stdin.Fd()))
-	if err != nil {
-		fmt.Fprintln(errors, "Error Reading Input")
-		panic(err)
-	}
-	defer term.Restore(int(os.Stdin.Fd()), prevState)
+var redirectOps = map[string]bool{
+	">": true, "1>": true, ">>": true, "1>>": true,
+	"2>": true, "2>>": true, "<": true,
+}
 
-	r := bufio.NewReader(reader)
-loop:
-	for {
-		ch, _, err := r.ReadRune()
-		if err != nil {
-			fmt.Println(err)
+func parseStage(tokens []string) *Stage {
+	s := &Stage{}
+	for i := 0; i < len(tokens); i++ {
+		if redirectOps[tokens[i]] && i+1 < len(tokens) {
+			s.Redirects = append(s.Redirects, Redirect{Op: tokens[i], Target: tokens[i+1]})
+			i++
 			continue
 		}
-		switch ch {
-		case '\x1b': //disable arrow keys while input
-			r.ReadRune() // to extract "[A"
-			r.ReadRune()
-		case '\x03': // Ctrl + C
-			os.Exit(0)
-		case '\x7F': //backspace
-			if length := len(input); length > 0 {
-				input = input[:length-1]
-				fmt.Fprint(os.Stdout, "\b \b")
-			}
-		case '\r', '\n': //enter
-			fmt.Fprint(os.Stdout, "\r\n")
-			break loop
-		case '\t': //tab
-			matches := autoComplete(input)
-			if len(matches) <= 1 {
-				if len(matches) != 0 {
-					input += matches[0] + " "
-					fmt.Fprint(os.Stdout, matches[0]+" ")
-				} else {
-					fmt.Print("\a")
-				}
-			} else {
-				partialMatch := longestCommonPrefix(matches)
-				if partialMatch == "" {
-					fmt.Print("\a")
-					next, _, err := r.ReadRune()
-					if err != nil {
-						fmt.Println(err)
-						continue
-					}
-					if next == '\t' {
-						slices.Sort(matches)
-						fmt.Fprint(os.Stdout, "\r\n")
-						for _, match := range matches {
-							fmt.Fprint(os.Stdout, input+match+"  ")
-						}
-						fmt.Fprint(os.Stdout, "\r\n$ ")
-						fmt.Fprint(os.Stdout, input)
-					}
-				} else {
-					fmt.Fprint(os.Stdout, "\r$ "+input+partialMatch)
-					input += partialMatch
-				}
-			}
-		default:
-			input += string(ch)
-			fmt.Fprint(os.Stdout, string(ch))
-		}
+		s.Argv = append(s.Argv, tokens[i])
 	}
-	return
+	return s
+}
+
+func parseInput(cmd string) *Pipeline {
+	cmd = strings.Trim(cmd, "\n\r")
+	// When ReadLine just recorded this line, editor.history's last entry
+	// is its expanded form, so look at everything before that for
+	// "!!"/"!n". Lines from -c, scripts and ~/.goshrc are never recorded.
+	priorHistory := editor.history
+	if n := len(priorHistory); n > 0 && priorHistory[n-1] == expandHistory(cmd, priorHistory[:n-1]) {
+		priorHistory = priorHistory[:n-1]
+	}
+	cmd = expandHistory(cmd, priorHistory)
+	p := buildPipeline(globExpand(tokenize(cmd)))
+	p.Raw = cmd
+	return p
 }
 
 func autoComplete(prefix string) []string {
@@ -293,69 +234,105 @@ func longestCommonPrefix(matches []string) string {
 	return prefix
 }
 
-func main() {
+// watchForegroundSignals catches SIGINT/SIGTSTP at the process level so
+// they never hit the shell itself, forwarding them to whichever process
+// group currently holds the foreground instead.
+func watchForegroundSignals() {
+	sigCh := make(chan os.Signal, 8)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTSTP)
+	go func() {
+		for sig := range sigCh {
+			if foregroundPgid == 0 {
+				continue
+			}
+			syscall.Kill(-foregroundPgid, sig.(syscall.Signal))
+		}
+	}()
+}
+
+func registerBuiltins() {
 	Handlers["exit"] = handleExit
 	Handlers["echo"] = handleEcho
 	Handlers["type"] = handleType
 	Handlers["pwd"] = handlePwd
 	Handlers["cd"] = handleCd
 	Handlers["cat"] = handleCat
+	Handlers["jobs"] = handleJobs
+	Handlers["fg"] = handleFg
+	Handlers["bg"] = handleBg
+	Handlers["wait"] = handleWait
+	Handlers["kill"] = handleKill
+	Handlers["history"] = handleHistory
+	Handlers["export"] = handleExport
+	Handlers["unset"] = handleUnset
+}
 
-	for {
-		fmt.Fprint(output, "$ ")
-		cmd := readInput(input)
+// runLine parses and executes a single line, the shared step behind the
+// interactive prompt, `-c`, script files and ~/.goshrc.
+func runLine(line string) {
+	p := parseInput(line)
+	if len(p.Stages) == 0 {
+		return
+	}
+	if err := executePipeline(p); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+	}
+}
 
-		parts := parseInput(cmd)
-		if len(parts) == 0 {
-			continue
-		}
-		cmd = parts[0]
-		var args []string
-		if len(parts) > 1 {
-			args = parts[1:]
-		}
-		for idx := 0; idx < len(args); idx++ {
-			if idx+1 == len(args) {
-				break
-			}
-			var isUsed bool = true
-			switch args[idx] {
-			case ">", "1>":
-				output = handleFileOpening(args[idx+1], os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0666, os.Stdout)
-			case ">>", "1>>":
-				output = handleFileOpening(args[idx+1], os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0666, os.Stdout)
-			case "2>":
-				errors = handleFileOpening(args[idx+1], os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0666, os.Stderr)
-			case "2>>":
-				errors = handleFileOpening(args[idx+1], os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0666, os.Stderr)
-			default:
-				isUsed = false
-			}
-			if isUsed {
-				args = append(args[:idx], args[idx+2:]...)
-				idx--
-			}
-		}
-		if fn, ok := Handlers[cmd]; ok {
-			err := fn(args)
-			if err != nil {
-				fmt.Fprintln(errors, err)
-			}
-		} else if _, ok := locateCmd(cmd); ok {
-			command := exec.Command(cmd, args...)
-			command.Stdout = output
-			command.Stderr = errors
-			_ = command.Run()
-		} else {
-			fmt.Fprintf(errors, "%s: command not found\n\r", cmd)
-		}
-		if output != nil && output != os.Stdout {
-			output.Close()
-		}
-		if errors != nil && errors != os.Stderr {
-			errors.Close()
-		}
-		output = os.Stdout
-		errors = os.Stderr
+// runScanner feeds every line of scanner through runLine, used for both
+// script files and ~/.goshrc — plain line-at-a-time reading, with no raw
+// terminal mode involved.
+func runScanner(scanner *bufio.Scanner) {
+	for scanner.Scan() {
+		runLine(scanner.Text())
+	}
+}
+
+// runScriptFile executes path as a shell script and reports a failure to
+// open it, unlike runRCFile which stays silent.
+func runScriptFile(path string) {
+	f, err := os.Open(path)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		lastStatus = 1
+		return
+	}
+	defer f.Close()
+	runScanner(bufio.NewScanner(f))
+}
+
+// runRCFile executes ~/.goshrc on interactive startup, if it exists.
+func runRCFile() {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return
+	}
+	f, err := os.Open(filepath.Join(home, ".goshrc"))
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	runScanner(bufio.NewScanner(f))
+}
+
+func main() {
+	registerBuiltins()
+	watchForegroundSignals()
+
+	args := os.Args[1:]
+	switch {
+	case len(args) >= 2 && args[0] == "-c":
+		runLine(args[1])
+		os.Exit(lastStatus)
+	case len(args) >= 1:
+		runScriptFile(args[0])
+		os.Exit(lastStatus)
+	}
+
+	runRCFile()
+	for {
+		reapJobs()
+		cmd := editor.ReadLine(input, "$ ")
+		runLine(cmd)
 	}
 }
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// Redirect describes a single `>`, `1>`, `>>`, `1>>`, `<`, `2>` or `2>>`
+// token pair parsed out of a pipeline stage.
+type Redirect struct {
+	Op     string // ">", "1>", ">>", "1>>", "<", "2>", "2>>"
+	Target string
+}
+
+// Stage is one command in a pipeline: `cat file | grep foo | wc -l` parses
+// into three stages joined by pipes.
+type Stage struct {
+	Argv      []string
+	Redirects []Redirect
+}
+
+// Pipeline is the parsed form of a full input line: a chain of stages,
+// the original text (used for job-control display) and whether it was
+// launched in the background with a trailing `&`.
+type Pipeline struct {
+	Stages     []*Stage
+	Background bool
+	Raw        string
+}
+
+// resolvedStage holds the concrete stdin/stdout/stderr a stage will run
+// with, after redirects and pipe-wiring have been applied. closers are
+// every *os.File this stage owns (inherited pipe ends plus any redirect
+// targets) and must be closed once the stage is dispatched: right after
+// Start() for an external command (the child has its own dup'd fd by
+// then), or once the builtin function returns.
+type resolvedStage struct {
+	argv    []string
+	stdin   io.Reader
+	stdout  io.Writer
+	stderr  io.Writer
+	closers []io.Closer
+}
+
+func (s *Stage) resolve(stdin io.Reader, stdout io.Writer, incoming, outgoing *os.File) (*resolvedStage, error) {
+	rs := &resolvedStage{argv: s.Argv, stdin: stdin, stdout: stdout, stderr: os.Stderr}
+	if incoming != nil {
+		rs.closers = append(rs.closers, incoming)
+	}
+	if outgoing != nil {
+		rs.closers = append(rs.closers, outgoing)
+	}
+	for _, r := range s.Redirects {
+		switch r.Op {
+		case ">", "1>":
+			f := handleFileOpening(r.Target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0666, os.Stdout)
+			rs.stdout = f
+			if f != os.Stdout && f != os.Stderr {
+				rs.closers = append(rs.closers, f)
+			}
+		case ">>", "1>>":
+			f := handleFileOpening(r.Target, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0666, os.Stdout)
+			rs.stdout = f
+			if f != os.Stdout && f != os.Stderr {
+				rs.closers = append(rs.closers, f)
+			}
+		case "2>":
+			f := handleFileOpening(r.Target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0666, os.Stderr)
+			rs.stderr = f
+			if f != os.Stdout && f != os.Stderr {
+				rs.closers = append(rs.closers, f)
+			}
+		case "2>>":
+			f := handleFileOpening(r.Target, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0666, os.Stderr)
+			rs.stderr = f
+			if f != os.Stdout && f != os.Stderr {
+				rs.closers = append(rs.closers, f)
+			}
+		case "<":
+			f, err := os.Open(r.Target)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "%s: No such file or directory\n", r.Target)
+				continue
+			}
+			rs.stdin = f
+			rs.closers = append(rs.closers, f)
+		}
+	}
+	return rs, nil
+}
+
+func (rs *resolvedStage) closeOwned() {
+	for _, c := range rs.closers {
+		c.Close()
+	}
+}
+
+// runBuiltin dispatches a builtin in-process; closers are only safe to
+// close once it returns, since (unlike an external command) it reads and
+// writes the same *os.File handles the shell holds.
+func (rs *resolvedStage) runBuiltin(fn func([]string, io.Reader, io.Writer, io.Writer) error) error {
+	defer rs.closeOwned()
+	return fn(rs.argv[1:], rs.stdin, rs.stdout, rs.stderr)
+}
+
+// executePipeline wires the pipeline's stages together with os.Pipe and
+// dispatches each stage: builtins run in-process goroutines, external
+// commands get their own process group (joining the pipeline's pgid) so
+// job control can signal or wait on the whole pipeline at once.
+func executePipeline(p *Pipeline) error {
+	return executePipelineIO(p, os.Stdin, os.Stdout)
+}
+
+// executePipelineIO is executePipeline with the outer stdin/stdout made
+// explicit, so command substitution can run a pipeline with its stdout
+// wired to a pipe instead of the shell's own.
+func executePipelineIO(p *Pipeline, defaultStdin io.Reader, defaultStdout io.Writer) error {
+	n := len(p.Stages)
+	if n == 0 {
+		return nil
+	}
+
+	var stdin io.Reader = defaultStdin
+	var incoming *os.File
+	resolved := make([]*resolvedStage, n)
+	for i, stage := range p.Stages {
+		var stdout io.Writer = defaultStdout
+		var outgoing *os.File
+		var nextStdin io.Reader
+		if i < n-1 {
+			r, w, err := os.Pipe()
+			if err != nil {
+				return err
+			}
+			stdout = w
+			outgoing = w
+			nextStdin = r
+		}
+		rs, err := stage.resolve(stdin, stdout, incoming, outgoing)
+		if err != nil {
+			return err
+		}
+		resolved[i] = rs
+		stdin = nextStdin
+		incoming = nil
+		if nextStdin != nil {
+			incoming = nextStdin.(*os.File)
+		}
+	}
+
+	var wg sync.WaitGroup
+	pgid := 0
+	lastIdx := n - 1
+	lastWasBuiltin := false
+	var lastBuiltinErr error
+	lastPid := 0
+	for i, rs := range resolved {
+		if len(rs.argv) == 0 {
+			rs.closeOwned()
+			continue
+		}
+		name := rs.argv[0]
+		if fn, ok := Handlers[name]; ok {
+			rs := rs
+			isLast := i == lastIdx
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				err := rs.runBuiltin(fn)
+				if err != nil {
+					fmt.Fprintln(rs.stderr, err)
+				}
+				if isLast {
+					lastWasBuiltin = true
+					lastBuiltinErr = err
+				}
+			}()
+			continue
+		}
+		if _, ok := locateCmd(name); !ok {
+			fmt.Fprintf(rs.stderr, "%s: command not found\n\r", name)
+			lastStatus = 127
+			rs.closeOwned()
+			continue
+		}
+		cmd := exec.Command(name, rs.argv[1:]...)
+		cmd.Stdin = rs.stdin
+		cmd.Stdout = rs.stdout
+		cmd.Stderr = rs.stderr
+		cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true, Pgid: pgid}
+		if err := cmd.Start(); err != nil {
+			fmt.Fprintln(rs.stderr, err)
+			rs.closeOwned()
+			continue
+		}
+		if pgid == 0 {
+			pgid = cmd.Process.Pid
+		}
+		if i == lastIdx {
+			lastPid = cmd.Process.Pid
+		}
+		rs.closeOwned()
+	}
+
+	if pgid == 0 {
+		// Pure builtin pipeline: nothing to job-control, just wait it out.
+		wg.Wait()
+		if lastWasBuiltin {
+			lastStatus = errStatus(lastBuiltinErr)
+		}
+		return nil
+	}
+
+	if p.Background {
+		addJob(pgid, lastPid, p.Raw)
+		fmt.Printf("[%d] %d\n", jobList[len(jobList)-1].ID, pgid)
+		lastStatus = 0
+		return nil
+	}
+
+	foregroundPgid = pgid
+	stopped, ws := waitForegroundPgid(pgid, lastPid)
+	foregroundPgid = 0
+	if stopped {
+		job := addJob(pgid, lastPid, p.Raw)
+		job.State = JobStopped
+		fmt.Printf("\n[%d]+  Stopped\t%s\n", job.ID, p.Raw)
+	}
+	wg.Wait()
+	switch {
+	case lastWasBuiltin:
+		lastStatus = errStatus(lastBuiltinErr)
+	case !stopped && lastPid != 0:
+		lastStatus = ws.ExitStatus()
+	}
+	return nil
+}
+
+func errStatus(err error) int {
+	if err != nil {
+		return 1
+	}
+	return 0
+}
+
+// runCommandSubstitution runs cmdText as a full pipeline with its stdout
+// captured instead of printed, for `$(...)` and backtick expansion. It
+// always waits for the pipeline to finish, ignoring any trailing `&`.
+func runCommandSubstitution(cmdText string) string {
+	p := parseInput(cmdText)
+	if len(p.Stages) == 0 {
+		return ""
+	}
+	p.Background = false
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		return ""
+	}
+	captured := make(chan string, 1)
+	go func() {
+		data, _ := io.ReadAll(r)
+		r.Close()
+		captured <- string(data)
+	}()
+
+	if err := executePipelineIO(p, os.Stdin, w); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+	}
+	w.Close()
+	return strings.TrimRight(<-captured, "\n")
+}